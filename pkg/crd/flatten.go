@@ -0,0 +1,448 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package crd
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	apiext "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1beta1"
+)
+
+// definitionsRefPrefix is the only form of $ref Flatten knows how to
+// inline: a same-document pointer into the root schema's own Definitions.
+const definitionsRefPrefix = "#/definitions/"
+
+// FlattenError describes a conflict or unsupported construct found while
+// flattening a schema, together with the JSON-pointer-style path (see
+// SchemaPath) of the node it was found at.
+type FlattenError struct {
+	Path SchemaPath
+	Msg  string
+}
+
+func (e *FlattenError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Path.String(), e.Msg)
+}
+
+// Flatten rewrites schema in place into an equivalent form with no $ref and
+// no Definitions: it inlines every $ref into a copy of the definition it
+// points to, merges allOf members into their parent schema wherever they're
+// compatible, and hoists the type that every oneOf/anyOf alternative shares
+// up onto the parent (only when the parent sets no type of its own). No other
+// constraint is hoisted out of oneOf/anyOf. The result is the flattened shape
+// the CRD v1 API actually accepts -- $ref and superfluous allOf nesting are
+// both rejected there.
+//
+// Flatten returns a *FlattenError (with a path pointing at the offending
+// node) if it finds a $ref it can't resolve, a $ref cycle, an allOf member
+// whose type, properties or other merged facets conflict with its parent's
+// (required is always unioned, so it never conflicts), or an allOf
+// member that sets a facet (enum, description, a nested oneOf/anyOf/not,
+// vendor extensions, ...) it doesn't know how to merge without silently
+// changing what the result accepts.
+func Flatten(schema *apiext.JSONSchemaProps) error {
+	if err := inlineRefs(schema, schema.Definitions, SchemaPath{{Location: Root}}, map[string]bool{}); err != nil {
+		return err
+	}
+
+	flat := &flattener{}
+	EditLocatedSchema(schema, flat)
+	if flat.err != nil {
+		return flat.err
+	}
+
+	schema.Definitions = nil
+	return nil
+}
+
+// inlineRefs replaces every $ref reachable from node (other than through
+// node.Definitions itself, which only ever holds targets, never referrers)
+// with a deep copy of the definition it points to, resolving chains of
+// $ref and detecting cycles as it goes.
+func inlineRefs(node *apiext.JSONSchemaProps, defs apiext.JSONSchemaDefinitions, path SchemaPath, resolving map[string]bool) error {
+	if node == nil {
+		return nil
+	}
+
+	for node.Ref != nil {
+		ref := *node.Ref
+		name := strings.TrimPrefix(ref, definitionsRefPrefix)
+		if name == "" || name == ref {
+			return &FlattenError{Path: path, Msg: fmt.Sprintf("unsupported $ref %q (Flatten only inlines %s<name> refs)", ref, definitionsRefPrefix)}
+		}
+		if resolving[name] {
+			return &FlattenError{Path: path, Msg: fmt.Sprintf("cyclic $ref to %s%s", definitionsRefPrefix, name)}
+		}
+		def, ok := defs[name]
+		if !ok {
+			return &FlattenError{Path: path, Msg: fmt.Sprintf("$ref to undefined definition %q", name)}
+		}
+		resolved, err := deepCopySchema(&def)
+		if err != nil {
+			return &FlattenError{Path: path, Msg: fmt.Sprintf("copying definition %q: %v", name, err)}
+		}
+		resolving[name] = true
+		defer delete(resolving, name)
+		*node = *resolved
+	}
+
+	if node.Items != nil {
+		if err := inlineRefs(node.Items.Schema, defs, path.with(SchemaStep{Location: ItemsSchema}), resolving); err != nil {
+			return err
+		}
+		for i := range node.Items.JSONSchemas {
+			if err := inlineRefs(&node.Items.JSONSchemas[i], defs, path.with(SchemaStep{Location: ItemsTupleElement, Index: i}), resolving); err != nil {
+				return err
+			}
+		}
+	}
+	for i := range node.AllOf {
+		if err := inlineRefs(&node.AllOf[i], defs, path.with(SchemaStep{Location: AllOfMember, Index: i}), resolving); err != nil {
+			return err
+		}
+	}
+	for i := range node.OneOf {
+		if err := inlineRefs(&node.OneOf[i], defs, path.with(SchemaStep{Location: OneOfMember, Index: i}), resolving); err != nil {
+			return err
+		}
+	}
+	for i := range node.AnyOf {
+		if err := inlineRefs(&node.AnyOf[i], defs, path.with(SchemaStep{Location: AnyOfMember, Index: i}), resolving); err != nil {
+			return err
+		}
+	}
+	if err := inlineRefs(node.Not, defs, path.with(SchemaStep{Location: NotSchema}), resolving); err != nil {
+		return err
+	}
+	for name, prop := range node.Properties {
+		prop := prop
+		if err := inlineRefs(&prop, defs, path.with(SchemaStep{Location: PropertyValue, Key: name}), resolving); err != nil {
+			return err
+		}
+		node.Properties[name] = prop
+	}
+	if node.AdditionalProperties != nil {
+		if err := inlineRefs(node.AdditionalProperties.Schema, defs, path.with(SchemaStep{Location: AdditionalPropertiesSchema}), resolving); err != nil {
+			return err
+		}
+	}
+	for name, pp := range node.PatternProperties {
+		pp := pp
+		if err := inlineRefs(&pp, defs, path.with(SchemaStep{Location: PatternPropertyValue, Key: name}), resolving); err != nil {
+			return err
+		}
+		node.PatternProperties[name] = pp
+	}
+	for name, dep := range node.Dependencies {
+		if err := inlineRefs(dep.Schema, defs, path.with(SchemaStep{Location: DependencySchema, Key: name}), resolving); err != nil {
+			return err
+		}
+		node.Dependencies[name] = dep
+	}
+	if node.AdditionalItems != nil {
+		if err := inlineRefs(node.AdditionalItems.Schema, defs, path.with(SchemaStep{Location: AdditionalItemsSchema}), resolving); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// deepCopySchema returns an independent copy of schema, so that inlining the
+// same definition at more than one $ref site doesn't leave the sites
+// aliasing (and so mutating) one another's maps and slices.
+func deepCopySchema(schema *apiext.JSONSchemaProps) (*apiext.JSONSchemaProps, error) {
+	raw, err := json.Marshal(schema)
+	if err != nil {
+		return nil, err
+	}
+	out := &apiext.JSONSchemaProps{}
+	if err := json.Unmarshal(raw, out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// flattener is the SchemaVisitor that performs Flatten's allOf-merging and
+// oneOf/anyOf-hoisting passes. It assumes $ref has already been inlined
+// (inlineRefs does that in a separate pass, since it needs to thread a
+// cycle-detection set that's keyed by definition name, not by node).
+type flattener struct {
+	err error
+}
+
+func (f *flattener) Visit(schema *apiext.JSONSchemaProps, path SchemaPath, level int) LocatedSchemaVisitor {
+	if schema == nil || f.err != nil {
+		return nil
+	}
+	if err := mergeAllOf(schema, path); err != nil {
+		f.err = err
+		return nil
+	}
+	if common := hoistCommonType(schema.OneOf); common != "" && schema.Type == "" {
+		schema.Type = common
+		stripBranchTypes(schema.OneOf)
+	}
+	if common := hoistCommonType(schema.AnyOf); common != "" && schema.Type == "" {
+		schema.Type = common
+		stripBranchTypes(schema.AnyOf)
+	}
+	return f
+}
+
+// mergeAllOf merges every member of schema.AllOf directly into schema,
+// provided each is compatible with what's already there. Once every member
+// has been merged, AllOf is cleared.
+func mergeAllOf(schema *apiext.JSONSchemaProps, path SchemaPath) error {
+	if len(schema.AllOf) == 0 {
+		return nil
+	}
+
+	for i, member := range schema.AllOf {
+		if err := mergeMemberInto(schema, &member, path.with(SchemaStep{Location: AllOfMember, Index: i})); err != nil {
+			return err
+		}
+	}
+
+	schema.AllOf = nil
+	return nil
+}
+
+// mergeMemberInto folds member, a single allOf member, into schema:
+// Properties are merged key by key (a colliding key is a conflict) and
+// Required is unioned in, since both can always be combined without losing
+// information. Type, format, pattern and the numeric, length, item-count and
+// property-count bounds are copied over verbatim if schema doesn't already
+// set them; if schema already sets one to a different value, that's a
+// conflict -- there's no single value that would honor both without changing
+// what the schema accepts. uniqueItems, exclusiveMaximum and exclusiveMinimum
+// are ORed together. items and additionalProperties are copied over if only
+// member sets them, and are a conflict whenever both sides set them. Facets
+// not named here or in forbiddenMemberFacet (such as title or example) are
+// not carried over from member.
+func mergeMemberInto(schema, member *apiext.JSONSchemaProps, path SchemaPath) error {
+	if facet := forbiddenMemberFacet(member); facet != "" {
+		return &FlattenError{Path: path, Msg: fmt.Sprintf("%s is set on an allOf member, which Flatten cannot merge without silently changing what the schema accepts", facet)}
+	}
+
+	if err := mergeStringField(&schema.Type, member.Type, "type", path); err != nil {
+		return err
+	}
+
+	for name, memberProp := range member.Properties {
+		if _, collides := schema.Properties[name]; collides {
+			return &FlattenError{Path: path.with(SchemaStep{Location: PropertyValue, Key: name}), Msg: fmt.Sprintf("property %q is also defined on the parent schema", name)}
+		}
+		if schema.Properties == nil {
+			schema.Properties = map[string]apiext.JSONSchemaProps{}
+		}
+		schema.Properties[name] = memberProp
+	}
+	schema.Required = unionStrings(schema.Required, member.Required)
+
+	if err := mergeStringField(&schema.Format, member.Format, "format", path); err != nil {
+		return err
+	}
+	if err := mergeStringField(&schema.Pattern, member.Pattern, "pattern", path); err != nil {
+		return err
+	}
+	if err := mergeFloat64Field(&schema.Maximum, member.Maximum, "maximum", path); err != nil {
+		return err
+	}
+	if err := mergeFloat64Field(&schema.Minimum, member.Minimum, "minimum", path); err != nil {
+		return err
+	}
+	if err := mergeFloat64Field(&schema.MultipleOf, member.MultipleOf, "multipleOf", path); err != nil {
+		return err
+	}
+	if err := mergeInt64Field(&schema.MaxLength, member.MaxLength, "maxLength", path); err != nil {
+		return err
+	}
+	if err := mergeInt64Field(&schema.MinLength, member.MinLength, "minLength", path); err != nil {
+		return err
+	}
+	if err := mergeInt64Field(&schema.MaxItems, member.MaxItems, "maxItems", path); err != nil {
+		return err
+	}
+	if err := mergeInt64Field(&schema.MinItems, member.MinItems, "minItems", path); err != nil {
+		return err
+	}
+	if err := mergeInt64Field(&schema.MaxProperties, member.MaxProperties, "maxProperties", path); err != nil {
+		return err
+	}
+	if err := mergeInt64Field(&schema.MinProperties, member.MinProperties, "minProperties", path); err != nil {
+		return err
+	}
+	schema.UniqueItems = schema.UniqueItems || member.UniqueItems
+	schema.ExclusiveMaximum = schema.ExclusiveMaximum || member.ExclusiveMaximum
+	schema.ExclusiveMinimum = schema.ExclusiveMinimum || member.ExclusiveMinimum
+
+	if member.Items != nil {
+		if schema.Items != nil {
+			return &FlattenError{Path: path, Msg: "items is set on both the parent schema and an allOf member"}
+		}
+		schema.Items = member.Items
+	}
+	if member.AdditionalProperties != nil {
+		if schema.AdditionalProperties != nil {
+			return &FlattenError{Path: path, Msg: "additionalProperties is set on both the parent schema and an allOf member"}
+		}
+		schema.AdditionalProperties = member.AdditionalProperties
+	}
+
+	return nil
+}
+
+// forbiddenMemberFacet returns the name of the first facet set on member
+// that mergeMemberInto doesn't know how to merge, or "" if there is none.
+// Each of these changes what the merged schema accepts in a way a plain
+// union or verbatim copy would get wrong -- merging two Enums isn't the
+// same as intersecting them, a member-level Description would shadow the
+// parent's, and so on -- so Flatten refuses to merge them rather than
+// silently dropping them.
+func forbiddenMemberFacet(member *apiext.JSONSchemaProps) string {
+	switch {
+	case len(member.Enum) > 0:
+		return "enum"
+	case member.Nullable:
+		return "nullable"
+	case member.Default != nil:
+		return "default"
+	case member.Description != "":
+		return "description"
+	case len(member.OneOf) > 0:
+		return "oneOf"
+	case len(member.AnyOf) > 0:
+		return "anyOf"
+	case member.Not != nil:
+		return "not"
+	case len(member.AllOf) > 0:
+		return "allOf"
+	case len(member.PatternProperties) > 0:
+		return "patternProperties"
+	case len(member.Dependencies) > 0:
+		return "dependencies"
+	case member.AdditionalItems != nil:
+		return "additionalItems"
+	case member.XPreserveUnknownFields != nil, member.XEmbeddedResource, member.XIntOrString,
+		len(member.XListMapKeys) > 0, member.XListType != nil, member.XMapType != nil:
+		return "x-kubernetes-* extensions"
+	default:
+		return ""
+	}
+}
+
+// mergeStringField merges a single string-valued facet: if member doesn't
+// set it, there's nothing to do; if schema doesn't set it yet, member's
+// value is adopted; if both set it to different values, that's a conflict.
+func mergeStringField(dst *string, src, name string, path SchemaPath) error {
+	if src == "" {
+		return nil
+	}
+	if *dst == "" {
+		*dst = src
+		return nil
+	}
+	if *dst != src {
+		return &FlattenError{Path: path, Msg: fmt.Sprintf("%s %q conflicts with parent %s %q", name, src, name, *dst)}
+	}
+	return nil
+}
+
+// mergeFloat64Field is mergeStringField for *float64-valued facets.
+func mergeFloat64Field(dst **float64, src *float64, name string, path SchemaPath) error {
+	if src == nil {
+		return nil
+	}
+	if *dst == nil {
+		*dst = src
+		return nil
+	}
+	if **dst != *src {
+		return &FlattenError{Path: path, Msg: fmt.Sprintf("%s %v conflicts with parent %s %v", name, *src, name, **dst)}
+	}
+	return nil
+}
+
+// mergeInt64Field is mergeStringField for *int64-valued facets.
+func mergeInt64Field(dst **int64, src *int64, name string, path SchemaPath) error {
+	if src == nil {
+		return nil
+	}
+	if *dst == nil {
+		*dst = src
+		return nil
+	}
+	if **dst != *src {
+		return &FlattenError{Path: path, Msg: fmt.Sprintf("%s %v conflicts with parent %s %v", name, *src, name, **dst)}
+	}
+	return nil
+}
+
+// hoistCommonType reports the Type that every member of branches shares in
+// common, or "" if branches is empty or its members don't all agree on a
+// (non-empty) Type. It doesn't mutate branches -- the caller strips the
+// per-branch Type (with stripBranchTypes) only once it's actually hoisted
+// the common value onto the parent, since a parent that already has a
+// (different) type has nowhere to put it and the branches must keep theirs.
+func hoistCommonType(branches []apiext.JSONSchemaProps) string {
+	if len(branches) == 0 {
+		return ""
+	}
+	common := branches[0].Type
+	if common == "" {
+		return ""
+	}
+	for _, member := range branches[1:] {
+		if member.Type != common {
+			return ""
+		}
+	}
+	return common
+}
+
+// stripBranchTypes clears Type on every member of branches, once its common
+// value has been hoisted onto the parent schema and repeating it on each
+// branch would be redundant.
+func stripBranchTypes(branches []apiext.JSONSchemaProps) {
+	for i := range branches {
+		branches[i].Type = ""
+	}
+}
+
+// unionStrings returns the sorted-free union of a and b, preserving a's
+// existing order and appending any new entries from b.
+func unionStrings(a, b []string) []string {
+	if len(b) == 0 {
+		return a
+	}
+	have := make(map[string]bool, len(a))
+	for _, s := range a {
+		have[s] = true
+	}
+	out := a
+	for _, s := range b {
+		if have[s] {
+			continue
+		}
+		have[s] = true
+		out = append(out, s)
+	}
+	return out
+}
@@ -0,0 +1,173 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package crd
+
+import (
+	"testing"
+
+	apiext "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1beta1"
+)
+
+func ref(name string) *string {
+	r := definitionsRefPrefix + name
+	return &r
+}
+
+// TestFlattenInlinesRefAndMergesAllOf covers the common Kubebuilder shape for
+// an embedded struct: a field whose schema is `allOf: [{$ref: ...}]` plus its
+// own properties, which controller-gen emits for `SomeType struct {
+// Embedded; Field int }`.
+func TestFlattenInlinesRefAndMergesAllOf(t *testing.T) {
+	schema := &apiext.JSONSchemaProps{
+		Type: "object",
+		Properties: map[string]apiext.JSONSchemaProps{
+			"spec": {
+				Type:     "object",
+				AllOf:    []apiext.JSONSchemaProps{{Ref: ref("CommonSpec")}},
+				Required: []string{"replicas"},
+				Properties: map[string]apiext.JSONSchemaProps{
+					"replicas": {Type: "integer"},
+				},
+			},
+		},
+		Definitions: apiext.JSONSchemaDefinitions{
+			"CommonSpec": {
+				Type:     "object",
+				Required: []string{"name"},
+				Properties: map[string]apiext.JSONSchemaProps{
+					"name": {Type: "string"},
+				},
+			},
+		},
+	}
+
+	if err := Flatten(schema); err != nil {
+		t.Fatalf("Flatten returned an error: %v", err)
+	}
+
+	spec := schema.Properties["spec"]
+	if spec.AllOf != nil {
+		t.Fatalf("expected allOf to be cleared after merging, got %v", spec.AllOf)
+	}
+	if _, ok := spec.Properties["name"]; !ok {
+		t.Fatalf("expected the embedded CommonSpec's `name` property to be merged in, got %v", spec.Properties)
+	}
+	if _, ok := spec.Properties["replicas"]; !ok {
+		t.Fatalf("expected the parent's own `replicas` property to survive merging, got %v", spec.Properties)
+	}
+	wantRequired := map[string]bool{"name": true, "replicas": true}
+	if len(spec.Required) != len(wantRequired) {
+		t.Fatalf("expected required to be the union of both sides, got %v", spec.Required)
+	}
+	for _, name := range spec.Required {
+		if !wantRequired[name] {
+			t.Fatalf("unexpected required entry %q, got %v", name, spec.Required)
+		}
+	}
+	if schema.Definitions != nil {
+		t.Fatalf("expected Definitions to be dropped once everything is inlined, got %v", schema.Definitions)
+	}
+}
+
+// TestFlattenKeepsSharedDefinitionsIndependent covers two embedded types that
+// reference the same shared nested struct (the same Definitions entry) --
+// inlining must deep-copy each site so that merging one doesn't mutate the
+// other.
+func TestFlattenKeepsSharedDefinitionsIndependent(t *testing.T) {
+	schema := &apiext.JSONSchemaProps{
+		Type: "object",
+		Properties: map[string]apiext.JSONSchemaProps{
+			"a": {Type: "object", AllOf: []apiext.JSONSchemaProps{{Ref: ref("Shared")}}},
+			"b": {Type: "object", AllOf: []apiext.JSONSchemaProps{{Ref: ref("Shared")}}},
+		},
+		Definitions: apiext.JSONSchemaDefinitions{
+			"Shared": {
+				Type:       "object",
+				Properties: map[string]apiext.JSONSchemaProps{"id": {Type: "string"}},
+			},
+		},
+	}
+
+	if err := Flatten(schema); err != nil {
+		t.Fatalf("Flatten returned an error: %v", err)
+	}
+
+	a, b := schema.Properties["a"], schema.Properties["b"]
+	if _, ok := a.Properties["id"]; !ok {
+		t.Fatalf("expected `a` to have the shared `id` property, got %v", a.Properties)
+	}
+	if _, ok := b.Properties["id"]; !ok {
+		t.Fatalf("expected `b` to have the shared `id` property, got %v", b.Properties)
+	}
+
+	idA := a.Properties["id"]
+	idA.Type = "integer"
+	a.Properties["id"] = idA
+	schema.Properties["a"] = a
+
+	if b.Properties["id"].Type != "string" {
+		t.Fatalf("mutating `a`'s copy of the shared property changed `b`'s copy too: %v", b.Properties["id"])
+	}
+}
+
+// TestFlattenRejectsUnmergeableAllOfFacet covers an allOf member that sets a
+// facet Flatten doesn't know how to merge (enum): it must be reported as a
+// conflict rather than silently dropped.
+func TestFlattenRejectsUnmergeableAllOfFacet(t *testing.T) {
+	schema := &apiext.JSONSchemaProps{
+		Type: "object",
+		AllOf: []apiext.JSONSchemaProps{
+			{Enum: []apiext.JSON{{Raw: []byte(`"a"`)}, {Raw: []byte(`"b"`)}}},
+		},
+	}
+
+	err := Flatten(schema)
+	if err == nil {
+		t.Fatalf("expected Flatten to reject an allOf member setting enum, got nil error")
+	}
+	flattenErr, ok := err.(*FlattenError)
+	if !ok {
+		t.Fatalf("expected a *FlattenError, got %T: %v", err, err)
+	}
+	if flattenErr.Path.String() != "/allOf/0" {
+		t.Fatalf("expected the error to point at the offending allOf member, got path %q", flattenErr.Path.String())
+	}
+}
+
+// TestFlattenPreservesBranchTypesWhenParentTypeSet covers hoistCommonType:
+// if the parent already declares a type, the common type shared by its
+// oneOf branches must not be stripped from them, since it will never be
+// reattached anywhere.
+func TestFlattenPreservesBranchTypesWhenParentTypeSet(t *testing.T) {
+	schema := &apiext.JSONSchemaProps{
+		Type: "string",
+		OneOf: []apiext.JSONSchemaProps{
+			{Type: "integer"},
+			{Type: "integer"},
+		},
+	}
+
+	if err := Flatten(schema); err != nil {
+		t.Fatalf("Flatten returned an error: %v", err)
+	}
+
+	for i, branch := range schema.OneOf {
+		if branch.Type != "integer" {
+			t.Fatalf("oneOf[%d] lost its type even though the parent's own type couldn't absorb it: %v", i, branch)
+		}
+	}
+}
@@ -0,0 +1,140 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package crd
+
+import (
+	apiext "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1beta1"
+)
+
+// ReducingSchemaVisitor walks a schema like LocatedSchemaVisitor, but is
+// meant for *collecting* information out of the tree rather than editing it:
+// SchemaVisitor's own "all children visited" call (Visit(nil, level)) has
+// nowhere to put a return value, so a SchemaVisitor can mutate a tree but
+// can never cleanly aggregate a result out of it.
+//
+// Enter plays the role of Visit on the way down, and additionally returns a
+// result for this node -- used as-is if Enter declines to visit children
+// (by returning a nil next visitor), or folded together with every child's
+// own result by Leave otherwise. There's no generic parameter (to keep this
+// usable without language support for generics); implementations that want
+// type safety on the result should assert it back to their own type.
+type ReducingSchemaVisitor interface {
+	// Enter is called for each schema node. If next is nil, the node's
+	// children are not visited, and result is used as this node's final
+	// reduction directly -- Leave is not called for this node.
+	Enter(schema *apiext.JSONSchemaProps, path SchemaPath, level int) (next ReducingSchemaVisitor, result interface{})
+	// Leave is called once every child has been visited (using the
+	// visitor Enter returned), with each child's reduction in traversal
+	// order, and returns this node's final reduction.
+	Leave(schema *apiext.JSONSchemaProps, path SchemaPath, level int, childResults []interface{}) interface{}
+}
+
+// EditSchemaReduce walks schema like EditSchema, but instead of relying
+// purely on in-place mutation, it threads a result value up from the leaves
+// to the root and returns the root's final reduction.
+func EditSchemaReduce(schema *apiext.JSONSchemaProps, visitor ReducingSchemaVisitor) interface{} {
+	seen := make(map[*apiext.JSONSchemaProps]struct{})
+	return reduceSchema(schema, visitor, SchemaPath{{Location: Root}}, 0, seen)
+}
+
+// reduceSchema mirrors schemaWalker.walkSchema's cycle guard: seen tracks
+// the schema nodes on the current path from the root, so a self-referential
+// Definitions/$ref graph is skipped rather than recursed into forever. A
+// cycle contributes a nil result to its parent's childResults, which every
+// built-in reducer already treats as "nothing here" via its childResults
+// type assertion.
+//
+// schema may be nil (e.g. RequiredPaths(nil)); unlike walkSchema, nothing
+// upstream guarantees a non-nil node before calling in, so that's checked
+// here too, before a visitor gets a chance to dereference it.
+func reduceSchema(schema *apiext.JSONSchemaProps, visitor ReducingSchemaVisitor, path SchemaPath, level int, seen map[*apiext.JSONSchemaProps]struct{}) interface{} {
+	if schema == nil {
+		return nil
+	}
+	if _, isCycle := seen[schema]; isCycle {
+		return nil
+	}
+	seen[schema] = struct{}{}
+	defer delete(seen, schema)
+
+	next, result := visitor.Enter(schema, path, level)
+	if next == nil {
+		return result
+	}
+
+	nextLevel := level + 1
+	childResults := reduceChildren(schema, next, path, nextLevel, seen)
+	return next.Leave(schema, path, level, childResults)
+}
+
+// reduceChildren visits the same set of child nodes walkSchema does, in the
+// same order, collecting each one's reduction.
+func reduceChildren(schema *apiext.JSONSchemaProps, visitor ReducingSchemaVisitor, path SchemaPath, level int, seen map[*apiext.JSONSchemaProps]struct{}) []interface{} {
+	var results []interface{}
+	collect := func(child *apiext.JSONSchemaProps, step SchemaStep) {
+		if child == nil {
+			return
+		}
+		results = append(results, reduceSchema(child, visitor, path.with(step), level, seen))
+	}
+
+	if schema.Items != nil {
+		collect(schema.Items.Schema, SchemaStep{Location: ItemsSchema})
+		for i := range schema.Items.JSONSchemas {
+			collect(&schema.Items.JSONSchemas[i], SchemaStep{Location: ItemsTupleElement, Index: i})
+		}
+	}
+	for i := range schema.AllOf {
+		collect(&schema.AllOf[i], SchemaStep{Location: AllOfMember, Index: i})
+	}
+	for i := range schema.OneOf {
+		collect(&schema.OneOf[i], SchemaStep{Location: OneOfMember, Index: i})
+	}
+	for i := range schema.AnyOf {
+		collect(&schema.AnyOf[i], SchemaStep{Location: AnyOfMember, Index: i})
+	}
+	collect(schema.Not, SchemaStep{Location: NotSchema})
+	for name, prop := range schema.Properties {
+		prop := prop
+		results = append(results, reduceSchema(&prop, visitor, path.with(SchemaStep{Location: PropertyValue, Key: name}), level, seen))
+		schema.Properties[name] = prop
+	}
+	if schema.AdditionalProperties != nil {
+		collect(schema.AdditionalProperties.Schema, SchemaStep{Location: AdditionalPropertiesSchema})
+	}
+	for name, pp := range schema.PatternProperties {
+		pp := pp
+		results = append(results, reduceSchema(&pp, visitor, path.with(SchemaStep{Location: PatternPropertyValue, Key: name}), level, seen))
+		schema.PatternProperties[name] = pp
+	}
+	for name, dep := range schema.Dependencies {
+		if dep.Schema != nil {
+			results = append(results, reduceSchema(dep.Schema, visitor, path.with(SchemaStep{Location: DependencySchema, Key: name}), level, seen))
+		}
+		schema.Dependencies[name] = dep
+	}
+	if schema.AdditionalItems != nil {
+		collect(schema.AdditionalItems.Schema, SchemaStep{Location: AdditionalItemsSchema})
+	}
+	for name, def := range schema.Definitions {
+		def := def
+		results = append(results, reduceSchema(&def, visitor, path.with(SchemaStep{Location: DefinitionValue, Key: name}), level, seen))
+		schema.Definitions[name] = def
+	}
+
+	return results
+}
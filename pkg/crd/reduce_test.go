@@ -0,0 +1,73 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package crd
+
+import (
+	"testing"
+
+	apiext "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1beta1"
+)
+
+// TestEditSchemaReduceTerminatesOnDirectCycle covers reduceSchema's cycle
+// guard (mirroring schemaWalker's) against a node that references itself
+// directly: without the guard this would recurse forever instead of
+// returning.
+func TestEditSchemaReduceTerminatesOnDirectCycle(t *testing.T) {
+	root := &apiext.JSONSchemaProps{Type: "object"}
+	root.Not = root
+
+	if depth := MaxDepth(root); depth < 0 {
+		t.Fatalf("expected a non-negative depth, got %d", depth)
+	}
+}
+
+// TestEditSchemaReduceTerminatesOnDefinitionsCycle covers the same guard
+// against a self-referential Definitions graph: a definition that refers
+// back up to the root.
+func TestEditSchemaReduceTerminatesOnDefinitionsCycle(t *testing.T) {
+	root := &apiext.JSONSchemaProps{Type: "object"}
+	node := apiext.JSONSchemaProps{
+		Type:                 "object",
+		AdditionalProperties: &apiext.JSONSchemaPropsOrBool{Schema: root},
+	}
+	root.Definitions = apiext.JSONSchemaDefinitions{"Node": node}
+
+	// The cycle is pruned before it contributes a depth, so the deepest
+	// reachable level is definitions[Node] itself (level 1), not the
+	// pruned back-reference to root.
+	if depth := MaxDepth(root); depth != 1 {
+		t.Fatalf("expected depth 1 (root -> definitions[Node], with the back-reference to root pruned), got %d", depth)
+	}
+}
+
+// TestEditSchemaReduceNilSafe covers reduceSchema's nil guard: passing a nil
+// schema (directly, or via the RequiredPaths/Extensions/MaxDepth helpers)
+// must return a zero result instead of panicking on a nil dereference.
+func TestEditSchemaReduceNilSafe(t *testing.T) {
+	if got := EditSchemaReduce(nil, maxDepthVisitor{}); got != nil {
+		t.Fatalf("expected EditSchemaReduce(nil, ...) to return nil, got %v", got)
+	}
+	if got := RequiredPaths(nil); len(got) != 0 {
+		t.Fatalf("expected RequiredPaths(nil) to return no paths, got %v", got)
+	}
+	if got := Extensions(nil); len(got) != 0 {
+		t.Fatalf("expected Extensions(nil) to return no extensions, got %v", got)
+	}
+	if got := MaxDepth(nil); got != 0 {
+		t.Fatalf("expected MaxDepth(nil) to be 0, got %d", got)
+	}
+}
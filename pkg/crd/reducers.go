@@ -0,0 +1,144 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package crd
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+
+	apiext "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1beta1"
+)
+
+// requiredPathsVisitor is a ReducingSchemaVisitor whose result values are
+// unused: it collects directly into paths as it descends instead, since a
+// required property only makes sense relative to the path of the node
+// declaring it, which Enter already has on hand.
+type requiredPathsVisitor struct {
+	paths *[]string
+}
+
+func (v requiredPathsVisitor) Enter(schema *apiext.JSONSchemaProps, path SchemaPath, level int) (ReducingSchemaVisitor, interface{}) {
+	for _, name := range schema.Required {
+		*v.paths = append(*v.paths, path.with(SchemaStep{Location: PropertyValue, Key: name}).String())
+	}
+	return v, nil
+}
+
+func (v requiredPathsVisitor) Leave(schema *apiext.JSONSchemaProps, path SchemaPath, level int, childResults []interface{}) interface{} {
+	return nil
+}
+
+// RequiredPaths returns the JSON-pointer-style path of every property any
+// node of schema marks as required (e.g. "/properties/spec/properties/name").
+func RequiredPaths(schema *apiext.JSONSchemaProps) []string {
+	var paths []string
+	EditSchemaReduce(schema, requiredPathsVisitor{paths: &paths})
+	return paths
+}
+
+// maxDepthVisitor is a ReducingSchemaVisitor whose result at every node is
+// the deepest level reached anywhere in its subtree.
+type maxDepthVisitor struct{}
+
+func (v maxDepthVisitor) Enter(schema *apiext.JSONSchemaProps, path SchemaPath, level int) (ReducingSchemaVisitor, interface{}) {
+	return v, level
+}
+
+func (v maxDepthVisitor) Leave(schema *apiext.JSONSchemaProps, path SchemaPath, level int, childResults []interface{}) interface{} {
+	deepest := level
+	for _, child := range childResults {
+		if childDepth, ok := child.(int); ok && childDepth > deepest {
+			deepest = childDepth
+		}
+	}
+	return deepest
+}
+
+// MaxDepth returns the deepest level any node of schema is nested at (the
+// root is level 0). CRD v1 rejects structural schemas nested more than 32
+// levels deep, so this is useful to check against that limit before
+// generation bothers emitting anything.
+func MaxDepth(schema *apiext.JSONSchemaProps) int {
+	depth, _ := EditSchemaReduce(schema, maxDepthVisitor{}).(int)
+	return depth
+}
+
+// Extension pairs the name of an `x-kubernetes-*` vendor extension with the
+// path of the schema node it's set on.
+type Extension struct {
+	Name string
+	Path string
+}
+
+// extensionsVisitor is a ReducingSchemaVisitor whose result values are
+// unused: like requiredPathsVisitor, it collects directly as it descends.
+type extensionsVisitor struct {
+	found *[]Extension
+}
+
+func (v extensionsVisitor) Enter(schema *apiext.JSONSchemaProps, path SchemaPath, level int) (ReducingSchemaVisitor, interface{}) {
+	record := func(name string) {
+		*v.found = append(*v.found, Extension{Name: name, Path: path.String()})
+	}
+	if schema.XPreserveUnknownFields != nil && *schema.XPreserveUnknownFields {
+		record("x-kubernetes-preserve-unknown-fields")
+	}
+	if schema.XEmbeddedResource {
+		record("x-kubernetes-embedded-resource")
+	}
+	if schema.XIntOrString {
+		record("x-kubernetes-int-or-string")
+	}
+	if len(schema.XListMapKeys) > 0 {
+		record("x-kubernetes-list-map-keys")
+	}
+	if schema.XListType != nil {
+		record("x-kubernetes-list-type")
+	}
+	if schema.XMapType != nil {
+		record("x-kubernetes-map-type")
+	}
+	return v, nil
+}
+
+func (v extensionsVisitor) Leave(schema *apiext.JSONSchemaProps, path SchemaPath, level int, childResults []interface{}) interface{} {
+	return nil
+}
+
+// Extensions returns every `x-kubernetes-*` vendor extension set anywhere in
+// schema, together with the path of the node it's set on.
+func Extensions(schema *apiext.JSONSchemaProps) []Extension {
+	var found []Extension
+	EditSchemaReduce(schema, extensionsVisitor{found: &found})
+	return found
+}
+
+// ContentHash returns a stable sha256 hex digest of schema's content,
+// suitable for change-detection in CI (e.g. "did the generated CRD schema
+// change since the last run"). It's computed directly from schema's
+// canonical JSON encoding rather than via EditSchemaReduce: encoding/json
+// already sorts map keys, which is exactly what our own traversal -- which
+// iterates Go maps in unspecified order -- can't promise on its own.
+func ContentHash(schema *apiext.JSONSchemaProps) (string, error) {
+	raw, err := json.Marshal(schema)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(raw)
+	return hex.EncodeToString(sum[:]), nil
+}
@@ -0,0 +1,125 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package crd
+
+import (
+	"sort"
+	"testing"
+
+	apiext "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1beta1"
+)
+
+// nestedSchema builds a small but realistic schema three levels deep:
+// root.spec.nested.leaf, with `required` set at more than one level, so
+// RequiredPaths and MaxDepth both have something to report.
+func nestedSchema() *apiext.JSONSchemaProps {
+	return &apiext.JSONSchemaProps{
+		Type:     "object",
+		Required: []string{"spec"},
+		Properties: map[string]apiext.JSONSchemaProps{
+			"spec": {
+				Type:     "object",
+				Required: []string{"nested"},
+				Properties: map[string]apiext.JSONSchemaProps{
+					"nested": {
+						Type: "object",
+						Properties: map[string]apiext.JSONSchemaProps{
+							"leaf": {Type: "string"},
+						},
+					},
+				},
+			},
+		},
+	}
+}
+
+func TestRequiredPaths(t *testing.T) {
+	paths := RequiredPaths(nestedSchema())
+	sort.Strings(paths)
+
+	want := []string{"/properties/spec", "/properties/spec/properties/nested"}
+	if len(paths) != len(want) {
+		t.Fatalf("expected %v, got %v", want, paths)
+	}
+	for i, p := range want {
+		if paths[i] != p {
+			t.Fatalf("expected %v, got %v", want, paths)
+		}
+	}
+}
+
+func TestMaxDepth(t *testing.T) {
+	if depth := MaxDepth(nestedSchema()); depth != 3 {
+		t.Fatalf("expected the leaf at root.spec.nested.leaf to be at depth 3, got %d", depth)
+	}
+}
+
+func TestExtensions(t *testing.T) {
+	preserve := true
+	listType := "map"
+	schema := &apiext.JSONSchemaProps{
+		Type:                   "object",
+		XPreserveUnknownFields: &preserve,
+		Properties: map[string]apiext.JSONSchemaProps{
+			"items": {
+				Type:      "array",
+				XListType: &listType,
+			},
+		},
+	}
+
+	found := Extensions(schema)
+	if len(found) != 2 {
+		t.Fatalf("expected 2 extensions, got %v", found)
+	}
+
+	byName := map[string]Extension{}
+	for _, e := range found {
+		byName[e.Name] = e
+	}
+	if e, ok := byName["x-kubernetes-preserve-unknown-fields"]; !ok || e.Path != "/" {
+		t.Fatalf("expected x-kubernetes-preserve-unknown-fields at the root, got %+v", found)
+	}
+	if e, ok := byName["x-kubernetes-list-type"]; !ok || e.Path != "/properties/items" {
+		t.Fatalf("expected x-kubernetes-list-type at /properties/items, got %+v", found)
+	}
+}
+
+func TestContentHashIsStableAndSensitiveToChanges(t *testing.T) {
+	schema := nestedSchema()
+
+	hash1, err := ContentHash(schema)
+	if err != nil {
+		t.Fatalf("ContentHash returned an error: %v", err)
+	}
+	hash2, err := ContentHash(schema)
+	if err != nil {
+		t.Fatalf("ContentHash returned an error: %v", err)
+	}
+	if hash1 != hash2 {
+		t.Fatalf("expected ContentHash to be stable across calls on the same schema, got %q and %q", hash1, hash2)
+	}
+
+	schema.Properties["spec"] = apiext.JSONSchemaProps{Type: "string"}
+	hash3, err := ContentHash(schema)
+	if err != nil {
+		t.Fatalf("ContentHash returned an error: %v", err)
+	}
+	if hash3 == hash1 {
+		t.Fatalf("expected ContentHash to change after the schema changed, got the same hash %q", hash3)
+	}
+}
@@ -17,9 +17,132 @@ limitations under the License.
 package crd
 
 import (
+	"strconv"
+	"strings"
+
 	apiext "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1beta1"
 )
 
+// SchemaLocation describes how a schema node was reached from its parent,
+// mirroring the shape of a node's possible children in the JSON Schema (and
+// OpenAPI) spec.
+type SchemaLocation int
+
+const (
+	// Root indicates the node passed to EditSchema itself.
+	Root SchemaLocation = iota
+	// PropertyValue indicates a node reached via a named entry of `properties`.
+	PropertyValue
+	// PatternPropertyValue indicates a node reached via a named entry of `patternProperties`.
+	PatternPropertyValue
+	// AdditionalPropertiesSchema indicates a node reached via `additionalProperties` (when it's a schema, not a bool).
+	AdditionalPropertiesSchema
+	// AllOfMember indicates a node reached via an entry of `allOf`.
+	AllOfMember
+	// OneOfMember indicates a node reached via an entry of `oneOf`.
+	OneOfMember
+	// AnyOfMember indicates a node reached via an entry of `anyOf`.
+	AnyOfMember
+	// NotSchema indicates a node reached via `not`.
+	NotSchema
+	// ItemsSchema indicates a node reached via `items` when it's a single schema.
+	ItemsSchema
+	// ItemsTupleElement indicates a node reached via an entry of `items` when it's a list of schemas (tuple-typed arrays).
+	ItemsTupleElement
+	// AdditionalItemsSchema indicates a node reached via `additionalItems` (when it's a schema, not a bool).
+	AdditionalItemsSchema
+	// DependencySchema indicates a node reached via a named entry of `dependencies` (when it's a schema, not a property list).
+	DependencySchema
+	// DefinitionValue indicates a node reached via a named entry of `definitions`.
+	DefinitionValue
+)
+
+// String returns a human-readable name for this location, as used by SchemaStep's String method.
+func (l SchemaLocation) String() string {
+	switch l {
+	case Root:
+		return "root"
+	case PropertyValue:
+		return "properties"
+	case PatternPropertyValue:
+		return "patternProperties"
+	case AdditionalPropertiesSchema:
+		return "additionalProperties"
+	case AllOfMember:
+		return "allOf"
+	case OneOfMember:
+		return "oneOf"
+	case AnyOfMember:
+		return "anyOf"
+	case NotSchema:
+		return "not"
+	case ItemsSchema:
+		return "items"
+	case ItemsTupleElement:
+		return "items"
+	case AdditionalItemsSchema:
+		return "additionalItems"
+	case DependencySchema:
+		return "dependencies"
+	case DefinitionValue:
+		return "definitions"
+	default:
+		return "unknown"
+	}
+}
+
+// SchemaStep is a single step taken from a parent schema node to reach one
+// of its children.  Key is set for map-keyed locations (properties,
+// patternProperties, dependencies, definitions); Index is set for
+// slice-keyed locations (allOf, oneOf, anyOf, tuple-typed items).  Neither is
+// set for single-schema locations (items, additionalProperties, not,
+// additionalItems) or for Root.
+type SchemaStep struct {
+	Location SchemaLocation
+	Key      string
+	Index    int
+}
+
+// String renders this step the way it would appear as a path segment in a
+// JSON pointer (RFC 6901) into the schema.
+func (s SchemaStep) String() string {
+	switch s.Location {
+	case Root:
+		return ""
+	case PropertyValue, PatternPropertyValue, DependencySchema, DefinitionValue:
+		return s.Location.String() + "/" + s.Key
+	case ItemsTupleElement, AllOfMember, OneOfMember, AnyOfMember:
+		return s.Location.String() + "/" + strconv.Itoa(s.Index)
+	default:
+		return s.Location.String()
+	}
+}
+
+// SchemaPath is the sequence of steps taken from the root of a schema to
+// reach a particular node.  The first element is always a Root step.
+type SchemaPath []SchemaStep
+
+// String renders this path as a JSON-pointer-style string (e.g.
+// "/properties/spec/items").
+func (p SchemaPath) String() string {
+	segments := make([]string, 0, len(p))
+	for _, step := range p {
+		if seg := step.String(); seg != "" {
+			segments = append(segments, seg)
+		}
+	}
+	return "/" + strings.Join(segments, "/")
+}
+
+// with returns a new path with the given step appended, without mutating
+// (or aliasing the backing array of) the receiver.
+func (p SchemaPath) with(step SchemaStep) SchemaPath {
+	next := make(SchemaPath, len(p)+1)
+	copy(next, p)
+	next[len(p)] = step
+	return next
+}
+
 // SchemaVisitor walks the nodes of a schema.
 type SchemaVisitor interface {
 	// Visit is called for each schema node.  If it returns a visitor,
@@ -31,59 +154,145 @@ type SchemaVisitor interface {
 	Visit(schema *apiext.JSONSchemaProps, level int) SchemaVisitor
 }
 
+// LocatedSchemaVisitor is a richer form of SchemaVisitor that is additionally
+// told *how* each node was reached, via the path of SchemaSteps taken from
+// the root.  This makes it possible to write visitors that care about
+// context -- e.g. "only under items", or "skip oneOf branches" -- which a
+// plain SchemaVisitor cannot distinguish, since every node looks the same to
+// it regardless of how it was reached.
+//
+// A plain SchemaVisitor is still passed to EditSchema, which wraps it so it
+// walks the same way a LocatedSchemaVisitor does, with the path simply not
+// passed on to it; EditLocatedSchema itself only takes a LocatedSchemaVisitor.
+type LocatedSchemaVisitor interface {
+	// Visit is called for each schema node, like SchemaVisitor.Visit, but is
+	// additionally passed the path taken from the root to reach this node
+	// (the first element of which is always a Root step).  As with
+	// SchemaVisitor.Visit, a nil schema indicates that all children have
+	// been visited, and path reflects the path to the node being left.
+	Visit(schema *apiext.JSONSchemaProps, path SchemaPath, level int) LocatedSchemaVisitor
+}
+
+// locatingVisitor adapts a plain SchemaVisitor to LocatedSchemaVisitor by
+// ignoring the path it's given.
+type locatingVisitor struct {
+	SchemaVisitor
+}
+
+func (v locatingVisitor) Visit(schema *apiext.JSONSchemaProps, _ SchemaPath, level int) LocatedSchemaVisitor {
+	next := v.SchemaVisitor.Visit(schema, level)
+	if next == nil {
+		return nil
+	}
+	return locatingVisitor{next}
+}
+
+// asLocated adapts visitor to a LocatedSchemaVisitor. A plain SchemaVisitor
+// and a LocatedSchemaVisitor both name their core method Visit, so nothing
+// can implement both at once; a SchemaVisitor passed in here is always
+// wrapped in a locatingVisitor to give it one.
+func asLocated(visitor SchemaVisitor) LocatedSchemaVisitor {
+	return locatingVisitor{visitor}
+}
+
+// CycleAwareSchemaVisitor is an optional extension to SchemaVisitor (or
+// LocatedSchemaVisitor) for visitors that want to know when schemaWalker
+// has run into a cycle, instead of having it silently pruned.  A cycle
+// happens when the exact same *JSONSchemaProps is reached twice along the
+// same path -- that is, when a pointer field of a node (such as Not, an
+// Items or AdditionalProperties schema, or a schema held in Definitions)
+// leads back to one of that node's own ancestors.  Structural schemas (CRD v1) forbid recursion
+// entirely, so a validating visitor will typically want to treat
+// VisitCycle as an error; others can simply ignore it and rely on the
+// walker's built-in pruning to avoid looping forever.
+type CycleAwareSchemaVisitor interface {
+	// VisitCycle is called instead of Visit when schema has already been
+	// visited earlier in the current path.  The walker does not descend
+	// into schema again regardless of what VisitCycle does.
+	VisitCycle(schema *apiext.JSONSchemaProps, level int)
+}
+
+func (v locatingVisitor) VisitCycle(schema *apiext.JSONSchemaProps, level int) {
+	if cycleAware, isCycleAware := v.SchemaVisitor.(CycleAwareSchemaVisitor); isCycleAware {
+		cycleAware.VisitCycle(schema, level)
+	}
+}
+
 // EditSchema walks the given schema using the given visitor.  Actual
 // pointers to each schema node are passed to the visitor, so any changes
 // made by the visitor will be reflected to the passed-in schema.
 func EditSchema(schema *apiext.JSONSchemaProps, visitor SchemaVisitor) {
-	walker := schemaWalker{visitor: visitor}
-	walker.walkSchema(schema, 0 /* level at the root is 0 */)
+	EditLocatedSchema(schema, asLocated(visitor))
+}
+
+// EditLocatedSchema is EditSchema for visitors that need to know how each
+// node was reached -- see LocatedSchemaVisitor.
+func EditLocatedSchema(schema *apiext.JSONSchemaProps, visitor LocatedSchemaVisitor) {
+	walker := schemaWalker{visitor: visitor, seen: make(map[*apiext.JSONSchemaProps]struct{})}
+	walker.walkSchema(schema, SchemaPath{{Location: Root}}, 0 /* level at the root is 0 */)
 }
 
 // schemaWalker knows how to walk the schema, saving modifications
 // made by the given visitor.
 type schemaWalker struct {
-	visitor SchemaVisitor
+	visitor LocatedSchemaVisitor
+	// seen tracks the schema nodes on the current path from the root, so
+	// that a schema which references itself (directly or through
+	// Definitions) is detected instead of walked forever.  It's shared
+	// (via the map's reference semantics) by every schemaWalker derived
+	// from this one, and entries are removed once their node's subtree
+	// has been fully walked, so it reflects ancestry, not the whole tree.
+	seen map[*apiext.JSONSchemaProps]struct{}
 }
 
 // walkSchema walks the given schema, saving modifications made by the
 // visitor (this is as simple as passing a pointer in most cases,
 // but special care needs to be taken to persist with maps).
-func (w schemaWalker) walkSchema(schema *apiext.JSONSchemaProps, level int) {
-	subVisitor := w.visitor.Visit(schema, level)
+func (w schemaWalker) walkSchema(schema *apiext.JSONSchemaProps, path SchemaPath, level int) {
+	if _, isCycle := w.seen[schema]; isCycle {
+		if cycleAware, isCycleAware := w.visitor.(CycleAwareSchemaVisitor); isCycleAware {
+			cycleAware.VisitCycle(schema, level)
+		}
+		return
+	}
+	w.seen[schema] = struct{}{}
+	defer delete(w.seen, schema)
+
+	subVisitor := w.visitor.Visit(schema, path, level)
 	if subVisitor == nil {
 		return
 	}
 	nextLevel := level + 1
-	defer subVisitor.Visit(nil, nextLevel)
+	defer subVisitor.Visit(nil, path, nextLevel)
 
-	subWalker := schemaWalker{visitor: subVisitor}
+	subWalker := schemaWalker{visitor: subVisitor, seen: w.seen}
 	if schema.Items != nil {
-		subWalker.walkPtr(schema.Items.Schema, nextLevel)
-		subWalker.walkSlice(schema.Items.JSONSchemas, nextLevel)
+		subWalker.walkPtr(schema.Items.Schema, SchemaStep{Location: ItemsSchema}, path, nextLevel)
+		subWalker.walkSlice(schema.Items.JSONSchemas, ItemsTupleElement, path, nextLevel)
 	}
-	subWalker.walkSlice(schema.AllOf, nextLevel)
-	subWalker.walkSlice(schema.OneOf, nextLevel)
-	subWalker.walkSlice(schema.AnyOf, nextLevel)
-	subWalker.walkPtr(schema.Not, nextLevel)
-	subWalker.walkMap(schema.Properties, nextLevel)
+	subWalker.walkSlice(schema.AllOf, AllOfMember, path, nextLevel)
+	subWalker.walkSlice(schema.OneOf, OneOfMember, path, nextLevel)
+	subWalker.walkSlice(schema.AnyOf, AnyOfMember, path, nextLevel)
+	subWalker.walkPtr(schema.Not, SchemaStep{Location: NotSchema}, path, nextLevel)
+	subWalker.walkMap(schema.Properties, PropertyValue, path, nextLevel)
 	if schema.AdditionalProperties != nil {
-		subWalker.walkPtr(schema.AdditionalProperties.Schema, nextLevel)
+		subWalker.walkPtr(schema.AdditionalProperties.Schema, SchemaStep{Location: AdditionalPropertiesSchema}, path, nextLevel)
 	}
-	subWalker.walkMap(schema.PatternProperties, nextLevel)
+	subWalker.walkMap(schema.PatternProperties, PatternPropertyValue, path, nextLevel)
 	for name, dep := range schema.Dependencies {
-		subWalker.walkPtr(dep.Schema, nextLevel)
+		subWalker.walkPtr(dep.Schema, SchemaStep{Location: DependencySchema, Key: name}, path, nextLevel)
 		schema.Dependencies[name] = dep
 	}
 	if schema.AdditionalItems != nil {
-		subWalker.walkPtr(schema.AdditionalItems.Schema, nextLevel)
+		subWalker.walkPtr(schema.AdditionalItems.Schema, SchemaStep{Location: AdditionalItemsSchema}, path, nextLevel)
 	}
-	subWalker.walkMap(schema.Definitions, nextLevel)
+	subWalker.walkMap(schema.Definitions, DefinitionValue, path, nextLevel)
 }
 
 // walkMap walks over values of the given map, saving changes to them.
-func (w schemaWalker) walkMap(defs map[string]apiext.JSONSchemaProps, level int) {
+func (w schemaWalker) walkMap(defs map[string]apiext.JSONSchemaProps, loc SchemaLocation, path SchemaPath, level int) {
 	for name, def := range defs {
-		w.walkSchema(&def, level)
+		w.walkSchema(&def, path.with(SchemaStep{Location: loc, Key: name}), level)
 		// make sure the edits actually go through since we can't
 		// take a reference to the value in the map
 		defs[name] = def
@@ -91,16 +300,16 @@ func (w schemaWalker) walkMap(defs map[string]apiext.JSONSchemaProps, level int)
 }
 
 // walkSlice walks over items of the given slice.
-func (w schemaWalker) walkSlice(defs []apiext.JSONSchemaProps, level int) {
+func (w schemaWalker) walkSlice(defs []apiext.JSONSchemaProps, loc SchemaLocation, path SchemaPath, level int) {
 	for i := range defs {
-		w.walkSchema(&defs[i], level)
+		w.walkSchema(&defs[i], path.with(SchemaStep{Location: loc, Index: i}), level)
 	}
 }
 
 // walkPtr walks over the contents of the given pointer, if it's not nil.
-func (w schemaWalker) walkPtr(def *apiext.JSONSchemaProps, level int) {
+func (w schemaWalker) walkPtr(def *apiext.JSONSchemaProps, step SchemaStep, path SchemaPath, level int) {
 	if def == nil {
 		return
 	}
-	w.walkSchema(def, level)
+	w.walkSchema(def, path.with(step), level)
 }
@@ -0,0 +1,98 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package crd
+
+import (
+	"testing"
+
+	apiext "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1beta1"
+)
+
+// recordingVisitor visits every node, keeping count of ordinary visits and
+// the schemas reported as cycles, so tests can assert on both without
+// needing to catch a stack overflow if the guard regresses.
+type recordingVisitor struct {
+	visits int
+	cycles []*apiext.JSONSchemaProps
+}
+
+func (v *recordingVisitor) Visit(schema *apiext.JSONSchemaProps, level int) SchemaVisitor {
+	if schema == nil {
+		return nil
+	}
+	v.visits++
+	return v
+}
+
+func (v *recordingVisitor) VisitCycle(schema *apiext.JSONSchemaProps, level int) {
+	v.cycles = append(v.cycles, schema)
+}
+
+func TestEditSchemaDetectsDirectCycle(t *testing.T) {
+	root := &apiext.JSONSchemaProps{Type: "object"}
+	root.Not = root // a node appearing as its own descendant
+
+	v := &recordingVisitor{}
+	EditSchema(root, v)
+
+	if len(v.cycles) != 1 || v.cycles[0] != root {
+		t.Fatalf("expected exactly one cycle reported for root, got %v", v.cycles)
+	}
+	if v.visits != 1 {
+		t.Fatalf("expected root to be visited exactly once, got %d visits", v.visits)
+	}
+}
+
+func TestEditSchemaDetectsDefinitionsCycle(t *testing.T) {
+	root := &apiext.JSONSchemaProps{Type: "object"}
+	node := apiext.JSONSchemaProps{
+		Type:                 "object",
+		AdditionalProperties: &apiext.JSONSchemaPropsOrBool{Schema: root},
+	}
+	root.Definitions = apiext.JSONSchemaDefinitions{"Node": node}
+
+	v := &recordingVisitor{}
+	EditSchema(root, v)
+
+	if len(v.cycles) != 1 || v.cycles[0] != root {
+		t.Fatalf("expected the definition's reference back to root to be reported as a cycle, got %v", v.cycles)
+	}
+	if v.visits != 2 {
+		t.Fatalf("expected 2 ordinary visits (root, definitions[Node]), got %d", v.visits)
+	}
+}
+
+func TestEditSchemaAllowsDiamonds(t *testing.T) {
+	shared := apiext.JSONSchemaProps{Type: "string"}
+	root := &apiext.JSONSchemaProps{
+		Type: "object",
+		Properties: map[string]apiext.JSONSchemaProps{
+			"a": shared,
+			"b": shared,
+		},
+	}
+
+	v := &recordingVisitor{}
+	EditSchema(root, v)
+
+	if len(v.cycles) != 0 {
+		t.Fatalf("two sibling properties with equal (but independently copied) values is not a cycle, got %v", v.cycles)
+	}
+	if v.visits != 3 {
+		t.Fatalf("expected 3 visits (root, properties[a], properties[b]), got %d", v.visits)
+	}
+}
@@ -0,0 +1,39 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package structural implements the Kubernetes "structural schema" form of a
+// CRD's JSONSchemaProps: generic, shape-describing facets (type, nullable,
+// items, properties, additionalProperties) kept separate from the facets
+// that constrain values (allOf/oneOf/anyOf/not and friends), plus the
+// x-kubernetes-* vendor extensions. See
+// https://github.com/kubernetes/community/blob/master/contributors/devel/sig-api-machinery/api-conventions.md#specifying-a-structural-schema
+// for the rules this form exists to make easy to check and enforce.
+//
+// Converting a schema with ToStructural and checking it with Validate is
+// meant to run as a pass over the schema the crd package produces, before
+// it's emitted as a v1 CustomResourceDefinition -- so that a schema which
+// the API server's own structural-schema pruning would otherwise silently
+// reinterpret (or reject outright) is instead caught and reported with a
+// precise path at generation time.
+//
+// TODO(generator-wiring): call ToStructural + Validate from the v1 CRD
+// generator entrypoint once it lands in this tree, so that a schema
+// violating structural-schema rules is caught at generation time instead of
+// only when the API server rejects (or silently prunes) it. That entrypoint
+// doesn't exist here yet, so this package's own tests are the only thing
+// exercising ToStructural and Validate for now -- tracked separately from
+// the rest of this package rather than folded silently into it.
+package structural
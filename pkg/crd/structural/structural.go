@@ -0,0 +1,241 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package structural
+
+import (
+	"fmt"
+	"reflect"
+
+	apiext "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1beta1"
+)
+
+// Generic holds the facets of a schema node that describe its *shape* --
+// type, nullability, defaulting, and documentation -- as opposed to the
+// facets that constrain the values it accepts (see ValueValidation) or the
+// Kubernetes vendor extensions attached to it (see Extensions).
+type Generic struct {
+	Type        string
+	Nullable    bool
+	Default     *apiext.JSON
+	Title       string
+	Description string
+}
+
+// ValueValidation holds the facets of a schema node that constrain the
+// *values* it accepts. Plain value validations (required, pattern, minimum,
+// and so on) may sit alongside `properties` or `items` on the same node, but
+// a node that composes allOf/oneOf/anyOf/not must not also declare
+// `properties` or `items` directly (see Validate).
+type ValueValidation struct {
+	Format           string
+	Maximum          *float64
+	ExclusiveMaximum bool
+	Minimum          *float64
+	ExclusiveMinimum bool
+	MaxLength        *int64
+	MinLength        *int64
+	Pattern          string
+	MaxItems         *int64
+	MinItems         *int64
+	UniqueItems      bool
+	MultipleOf       *float64
+	Enum             []apiext.JSON
+	MaxProperties    *int64
+	MinProperties    *int64
+	Required         []string
+	AllOf            []ValueValidation
+	OneOf            []ValueValidation
+	AnyOf            []ValueValidation
+	Not              *ValueValidation
+}
+
+// composes reports whether vv composes other schemas via allOf/oneOf/anyOf/
+// not, as opposed to merely constraining values directly (required,
+// pattern, minimum, and so on, all of which are fine alongside properties
+// or items on the same node).
+func (vv *ValueValidation) composes() bool {
+	return len(vv.AllOf) > 0 || len(vv.OneOf) > 0 || len(vv.AnyOf) > 0 || vv.Not != nil
+}
+
+// Extensions holds the Kubernetes `x-kubernetes-*` vendor extensions
+// attached to a schema node.
+type Extensions struct {
+	PreserveUnknownFields *bool
+	EmbeddedResource      bool
+	IntOrString           bool
+	ListMapKeys           []string
+	ListType              *string
+	MapType               *string
+}
+
+// Structural is the structural-schema form of a *apiext.JSONSchemaProps: its
+// Generic and Extensions facets live directly on the node next to its
+// children, while anything that constrains values rather than shape is
+// split out into ValueValidation.
+type Structural struct {
+	Generic
+	*ValueValidation
+	Extensions
+
+	Properties           map[string]Structural
+	Items                *Structural
+	AdditionalProperties *StructuralOrBool
+}
+
+// StructuralOrBool is the structural-schema analogue of
+// apiext.JSONSchemaPropsOrBool: `additionalProperties` is either a nested
+// schema or a plain bool.
+type StructuralOrBool struct {
+	Structural *Structural
+	Bool       bool
+}
+
+// ToStructural converts schema into its structural-schema form. It reshapes
+// the node, it does not check the structural-schema rules -- use Validate
+// for that once the whole tree has been converted.
+func ToStructural(schema *apiext.JSONSchemaProps) (*Structural, error) {
+	if schema == nil {
+		return nil, nil
+	}
+	if schema.Ref != nil {
+		return nil, fmt.Errorf("structural schemas must not contain $ref (found %q) -- flatten the schema first", *schema.Ref)
+	}
+
+	out := &Structural{
+		Generic: Generic{
+			Type:        schema.Type,
+			Nullable:    schema.Nullable,
+			Default:     schema.Default,
+			Title:       schema.Title,
+			Description: schema.Description,
+		},
+		Extensions: Extensions{
+			PreserveUnknownFields: schema.XPreserveUnknownFields,
+			EmbeddedResource:      schema.XEmbeddedResource,
+			IntOrString:           schema.XIntOrString,
+			ListMapKeys:           schema.XListMapKeys,
+			ListType:              schema.XListType,
+			MapType:               schema.XMapType,
+		},
+	}
+
+	vv, err := toValueValidation(schema)
+	if err != nil {
+		return nil, err
+	}
+	out.ValueValidation = vv
+
+	if len(schema.Properties) > 0 {
+		out.Properties = make(map[string]Structural, len(schema.Properties))
+		for name, prop := range schema.Properties {
+			prop := prop
+			propStruct, err := ToStructural(&prop)
+			if err != nil {
+				return nil, fmt.Errorf("properties[%s]: %w", name, err)
+			}
+			out.Properties[name] = *propStruct
+		}
+	}
+
+	if schema.Items != nil {
+		if len(schema.Items.JSONSchemas) > 0 {
+			return nil, fmt.Errorf("structural schemas do not support tuple-typed items")
+		}
+		items, err := ToStructural(schema.Items.Schema)
+		if err != nil {
+			return nil, fmt.Errorf("items: %w", err)
+		}
+		out.Items = items
+	}
+
+	if schema.AdditionalProperties != nil {
+		if schema.AdditionalProperties.Schema != nil {
+			nested, err := ToStructural(schema.AdditionalProperties.Schema)
+			if err != nil {
+				return nil, fmt.Errorf("additionalProperties: %w", err)
+			}
+			out.AdditionalProperties = &StructuralOrBool{Structural: nested}
+		} else {
+			out.AdditionalProperties = &StructuralOrBool{Bool: schema.AdditionalProperties.Allows}
+		}
+	}
+
+	return out, nil
+}
+
+// toValueValidation extracts the value-validation facets of schema, or
+// returns a nil *ValueValidation if none are set.
+func toValueValidation(schema *apiext.JSONSchemaProps) (*ValueValidation, error) {
+	vv := &ValueValidation{
+		Format:           schema.Format,
+		Maximum:          schema.Maximum,
+		ExclusiveMaximum: schema.ExclusiveMaximum,
+		Minimum:          schema.Minimum,
+		ExclusiveMinimum: schema.ExclusiveMinimum,
+		MaxLength:        schema.MaxLength,
+		MinLength:        schema.MinLength,
+		Pattern:          schema.Pattern,
+		MaxItems:         schema.MaxItems,
+		MinItems:         schema.MinItems,
+		UniqueItems:      schema.UniqueItems,
+		MultipleOf:       schema.MultipleOf,
+		Enum:             schema.Enum,
+		MaxProperties:    schema.MaxProperties,
+		MinProperties:    schema.MinProperties,
+		Required:         schema.Required,
+	}
+
+	for i := range schema.AllOf {
+		member, err := toValueValidation(&schema.AllOf[i])
+		if err != nil {
+			return nil, fmt.Errorf("allOf[%d]: %w", i, err)
+		}
+		if member != nil {
+			vv.AllOf = append(vv.AllOf, *member)
+		}
+	}
+	for i := range schema.OneOf {
+		member, err := toValueValidation(&schema.OneOf[i])
+		if err != nil {
+			return nil, fmt.Errorf("oneOf[%d]: %w", i, err)
+		}
+		if member != nil {
+			vv.OneOf = append(vv.OneOf, *member)
+		}
+	}
+	for i := range schema.AnyOf {
+		member, err := toValueValidation(&schema.AnyOf[i])
+		if err != nil {
+			return nil, fmt.Errorf("anyOf[%d]: %w", i, err)
+		}
+		if member != nil {
+			vv.AnyOf = append(vv.AnyOf, *member)
+		}
+	}
+	if schema.Not != nil {
+		not, err := toValueValidation(schema.Not)
+		if err != nil {
+			return nil, fmt.Errorf("not: %w", err)
+		}
+		vv.Not = not
+	}
+
+	if reflect.DeepEqual(*vv, ValueValidation{}) {
+		return nil, nil
+	}
+	return vv, nil
+}
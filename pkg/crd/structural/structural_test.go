@@ -0,0 +1,62 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package structural
+
+import (
+	"testing"
+
+	apiext "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1beta1"
+)
+
+func TestToStructuralRejectsRef(t *testing.T) {
+	ref := "#/definitions/Foo"
+	_, err := ToStructural(&apiext.JSONSchemaProps{Ref: &ref})
+	if err == nil {
+		t.Fatalf("expected an error converting a schema that still contains a $ref")
+	}
+}
+
+func TestToStructuralSplitsFacets(t *testing.T) {
+	schema := &apiext.JSONSchemaProps{
+		Type:     "object",
+		Required: []string{"name"},
+		Properties: map[string]apiext.JSONSchemaProps{
+			"name": {Type: "string", MinLength: int64Ptr(1)},
+		},
+	}
+
+	out, err := ToStructural(schema)
+	if err != nil {
+		t.Fatalf("ToStructural returned an error: %v", err)
+	}
+
+	if out.Type != "object" {
+		t.Fatalf("expected the Generic facet to carry Type, got %q", out.Type)
+	}
+	if out.ValueValidation == nil || len(out.ValueValidation.Required) != 1 || out.ValueValidation.Required[0] != "name" {
+		t.Fatalf("expected the ValueValidation facet to carry Required, got %+v", out.ValueValidation)
+	}
+	name, ok := out.Properties["name"]
+	if !ok {
+		t.Fatalf("expected Properties to carry the name property, got %+v", out.Properties)
+	}
+	if name.ValueValidation == nil || name.ValueValidation.MinLength == nil || *name.ValueValidation.MinLength != 1 {
+		t.Fatalf("expected name's MinLength to land in its own ValueValidation, got %+v", name.ValueValidation)
+	}
+}
+
+func int64Ptr(v int64) *int64 { return &v }
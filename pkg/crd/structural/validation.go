@@ -0,0 +1,104 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package structural
+
+import "fmt"
+
+// ValidationError describes a single structural-schema rule violation found
+// by Validate, together with the path (in `properties`/`items`-style dotted
+// form) of the node that violates it.
+type ValidationError struct {
+	Path string
+	Msg  string
+}
+
+func (e *ValidationError) Error() string {
+	if e.Path == "" {
+		return e.Msg
+	}
+	return fmt.Sprintf("%s: %s", e.Path, e.Msg)
+}
+
+// Validate checks root against the structural-schema rules the Kubernetes
+// API server enforces on CRD v1 schemas, returning every violation found
+// (not just the first), so that a generator can report them all at once
+// instead of forcing users through a fix-one-rerun-repeat loop:
+//
+//   - every node other than the root must declare a type
+//   - a node that composes allOf/oneOf/anyOf/not must not also declare
+//     properties or items directly (those belong under the composed
+//     members instead); plain value validations like required, pattern,
+//     or minimum are fine alongside properties/items
+//   - additionalProperties: false must not be combined with
+//     x-kubernetes-preserve-unknown-fields
+//
+// Cycles are not checked for here: ToStructural rejects any schema
+// containing a $ref, and allocates a new Structural for every node it
+// converts, so the tree it returns never points back to an ancestor.
+// ToStructural does not check its input for pointer cycles (a node reached
+// again through its own Not, Items or AdditionalProperties), and neither
+// does Validate.
+func Validate(root *Structural) []error {
+	var errs []error
+	validate(root, true, "", &errs)
+	return errs
+}
+
+func validate(node *Structural, isRoot bool, path string, errs *[]error) {
+	if node == nil {
+		return
+	}
+
+	if !isRoot && node.Type == "" {
+		*errs = append(*errs, &ValidationError{Path: path, Msg: "must have a type"})
+	}
+
+	if node.ValueValidation != nil && node.ValueValidation.composes() {
+		if len(node.Properties) > 0 {
+			*errs = append(*errs, &ValidationError{Path: path, Msg: "must not use properties together with allOf/oneOf/anyOf/not"})
+		}
+		if node.Items != nil {
+			*errs = append(*errs, &ValidationError{Path: path, Msg: "must not use items together with allOf/oneOf/anyOf/not"})
+		}
+	}
+
+	if node.AdditionalProperties != nil && !node.AdditionalProperties.Bool && node.AdditionalProperties.Structural == nil &&
+		node.PreserveUnknownFields != nil && *node.PreserveUnknownFields {
+		*errs = append(*errs, &ValidationError{Path: path, Msg: "must not set additionalProperties: false together with x-kubernetes-preserve-unknown-fields"})
+	}
+
+	for name, prop := range node.Properties {
+		validate(&prop, false, childPath(path, "properties", name), errs)
+	}
+	if node.Items != nil {
+		validate(node.Items, false, childPath(path, "items", ""), errs)
+	}
+	if node.AdditionalProperties != nil {
+		validate(node.AdditionalProperties.Structural, false, childPath(path, "additionalProperties", ""), errs)
+	}
+}
+
+func childPath(parent, field, key string) string {
+	seg := field
+	if key != "" {
+		seg = field + "[" + key + "]"
+	}
+	if parent == "" {
+		return seg
+	}
+	return parent + "." + seg
+}
@@ -0,0 +1,101 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package structural
+
+import (
+	"testing"
+
+	apiext "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1beta1"
+)
+
+// TestValidateAllowsRequiredWithProperties covers the most common CRD spec
+// shape -- an object node with both `required` and `properties` -- which
+// must not be flagged: `required` is a plain value validation, not a
+// composition keyword, and is fine alongside properties.
+func TestValidateAllowsRequiredWithProperties(t *testing.T) {
+	root, err := ToStructural(&apiext.JSONSchemaProps{
+		Type:     "object",
+		Required: []string{"name"},
+		Properties: map[string]apiext.JSONSchemaProps{
+			"name": {Type: "string"},
+		},
+	})
+	if err != nil {
+		t.Fatalf("ToStructural returned an error: %v", err)
+	}
+
+	if errs := Validate(root); len(errs) != 0 {
+		t.Fatalf("expected no violations for required+properties, got %v", errs)
+	}
+}
+
+// TestValidateRejectsPropertiesUnderComposition covers the rule
+// Validate does still enforce: a node can't declare properties directly
+// alongside allOf/oneOf/anyOf/not composition.
+func TestValidateRejectsPropertiesUnderComposition(t *testing.T) {
+	root, err := ToStructural(&apiext.JSONSchemaProps{
+		Type: "object",
+		AllOf: []apiext.JSONSchemaProps{
+			{Required: []string{"name"}},
+		},
+		Properties: map[string]apiext.JSONSchemaProps{
+			"name": {Type: "string"},
+		},
+	})
+	if err != nil {
+		t.Fatalf("ToStructural returned an error: %v", err)
+	}
+
+	errs := Validate(root)
+	if len(errs) != 1 {
+		t.Fatalf("expected exactly one violation, got %v", errs)
+	}
+}
+
+func TestValidateRequiresTypeOnNonRoot(t *testing.T) {
+	root, err := ToStructural(&apiext.JSONSchemaProps{
+		Type: "object",
+		Properties: map[string]apiext.JSONSchemaProps{
+			"untyped": {},
+		},
+	})
+	if err != nil {
+		t.Fatalf("ToStructural returned an error: %v", err)
+	}
+
+	errs := Validate(root)
+	if len(errs) != 1 {
+		t.Fatalf("expected exactly one violation for the untyped property, got %v", errs)
+	}
+}
+
+func TestValidateRejectsPreserveUnknownFieldsWithClosedAdditionalProperties(t *testing.T) {
+	preserve := true
+	root, err := ToStructural(&apiext.JSONSchemaProps{
+		Type:                   "object",
+		XPreserveUnknownFields: &preserve,
+		AdditionalProperties:   &apiext.JSONSchemaPropsOrBool{Allows: false},
+	})
+	if err != nil {
+		t.Fatalf("ToStructural returned an error: %v", err)
+	}
+
+	errs := Validate(root)
+	if len(errs) != 1 {
+		t.Fatalf("expected exactly one violation, got %v", errs)
+	}
+}
@@ -0,0 +1,74 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package structural
+
+// Visitor walks a Structural tree. Unlike crd.SchemaVisitor, it exposes each
+// of a node's three facets separately, so a caller can act only on the part
+// it actually cares about and return false from the other callbacks (e.g. a
+// defaulting pass only needs Structural, a validation-marker pass only needs
+// ValueValidation).
+type Visitor interface {
+	// Structural is called with the generic facets of every node.
+	// changed should be true if it mutated g.
+	Structural(g *Generic, level int) (changed bool)
+	// ValueValidation is called with the value-validation facets of a
+	// node, but only when the node has any (i.e. its ValueValidation is
+	// non-nil). changed should be true if it mutated v.
+	ValueValidation(v *ValueValidation, level int) (changed bool)
+	// Extensions is called with the vendor-extension facets of every
+	// node. changed should be true if it mutated x.
+	Extensions(x *Extensions, level int) (changed bool)
+}
+
+// EditStructural walks root, calling visitor on each node's facets (in the
+// order Structural, then ValueValidation if present, then Extensions), and
+// recursing into Properties, Items, and AdditionalProperties. It reports
+// whether any callback reported a change.
+func EditStructural(root *Structural, visitor Visitor) bool {
+	return editStructural(root, visitor, 0)
+}
+
+func editStructural(node *Structural, visitor Visitor, level int) bool {
+	if node == nil {
+		return false
+	}
+
+	changed := visitor.Structural(&node.Generic, level)
+	if node.ValueValidation != nil && visitor.ValueValidation(node.ValueValidation, level) {
+		changed = true
+	}
+	if visitor.Extensions(&node.Extensions, level) {
+		changed = true
+	}
+
+	nextLevel := level + 1
+	for name, prop := range node.Properties {
+		prop := prop
+		if editStructural(&prop, visitor, nextLevel) {
+			changed = true
+		}
+		node.Properties[name] = prop
+	}
+	if editStructural(node.Items, visitor, nextLevel) {
+		changed = true
+	}
+	if node.AdditionalProperties != nil && editStructural(node.AdditionalProperties.Structural, visitor, nextLevel) {
+		changed = true
+	}
+
+	return changed
+}
@@ -0,0 +1,101 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package structural
+
+import "testing"
+
+// trackingVisitor records the level it was called at for each facet, and
+// optionally mutates Extensions (reporting changed) at a chosen level, so
+// tests can check both traversal coverage and changed-propagation without
+// needing two separate Visitor implementations.
+type trackingVisitor struct {
+	structuralLevels      []int
+	valueValidationLevels []int
+	extensionLevels       []int
+	mutateAt              int
+}
+
+func (v *trackingVisitor) Structural(g *Generic, level int) bool {
+	v.structuralLevels = append(v.structuralLevels, level)
+	return false
+}
+
+func (v *trackingVisitor) ValueValidation(vv *ValueValidation, level int) bool {
+	v.valueValidationLevels = append(v.valueValidationLevels, level)
+	return false
+}
+
+func (v *trackingVisitor) Extensions(x *Extensions, level int) bool {
+	v.extensionLevels = append(v.extensionLevels, level)
+	if level == v.mutateAt {
+		x.EmbeddedResource = true
+		return true
+	}
+	return false
+}
+
+// buildTree returns a root with one property that has a ValueValidation and
+// one additionalProperties child that doesn't, so tests can tell the two
+// apart.
+func buildTree() *Structural {
+	return &Structural{
+		Generic: Generic{Type: "object"},
+		Properties: map[string]Structural{
+			"a": {
+				Generic:         Generic{Type: "string"},
+				ValueValidation: &ValueValidation{Pattern: "^a$"},
+			},
+		},
+		AdditionalProperties: &StructuralOrBool{
+			Structural: &Structural{Generic: Generic{Type: "integer"}},
+		},
+	}
+}
+
+func TestEditStructuralVisitsEveryFacetAndChild(t *testing.T) {
+	root := buildTree()
+	v := &trackingVisitor{mutateAt: -1}
+
+	if changed := EditStructural(root, v); changed {
+		t.Fatalf("expected no changes reported, got true")
+	}
+
+	if len(v.structuralLevels) != 3 {
+		t.Fatalf("expected Structural to be called once per node (root, properties[a], additionalProperties), got %d calls: %v", len(v.structuralLevels), v.structuralLevels)
+	}
+	if len(v.extensionLevels) != 3 {
+		t.Fatalf("expected Extensions to be called once per node regardless of content, got %d calls: %v", len(v.extensionLevels), v.extensionLevels)
+	}
+	if len(v.valueValidationLevels) != 1 || v.valueValidationLevels[0] != 1 {
+		t.Fatalf("expected ValueValidation to be called only for properties[a], the only node with one set, got %v", v.valueValidationLevels)
+	}
+}
+
+func TestEditStructuralPropagatesChanged(t *testing.T) {
+	root := buildTree()
+	v := &trackingVisitor{mutateAt: 1}
+
+	if changed := EditStructural(root, v); !changed {
+		t.Fatalf("expected a mutation in a child node to propagate changed=true up to the root")
+	}
+	if !root.Properties["a"].Extensions.EmbeddedResource {
+		t.Fatalf("expected the mutation to land on properties[a]'s Extensions")
+	}
+	if !root.AdditionalProperties.Structural.Extensions.EmbeddedResource {
+		t.Fatalf("expected the mutation to land on additionalProperties' Extensions")
+	}
+}